@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// decodeBencode reads a single bencoded value from r. Dictionaries decode to
+// map[string]any, lists to []any, byte strings to string, and integers to
+// int64. It is just enough of a decoder to read .torrent metainfo files; it
+// does not attempt to support every corner of BEP 3 (e.g. it assumes
+// dictionary keys are always byte strings, which torrent files guarantee).
+func decodeBencode(r *bufio.Reader) (any, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b == 'i':
+		return decodeBencodeInt(r)
+	case b == 'l':
+		var list []any
+		for {
+			peek, err := r.Peek(1)
+			if err != nil {
+				return nil, err
+			}
+			if peek[0] == 'e' {
+				r.ReadByte()
+				return list, nil
+			}
+			v, err := decodeBencode(r)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+	case b == 'd':
+		dict := make(map[string]any)
+		for {
+			peek, err := r.Peek(1)
+			if err != nil {
+				return nil, err
+			}
+			if peek[0] == 'e' {
+				r.ReadByte()
+				return dict, nil
+			}
+			key, err := decodeBencodeString(r)
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeBencode(r)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = value
+		}
+	case b >= '0' && b <= '9':
+		r.UnreadByte()
+		return decodeBencodeString(r)
+	default:
+		return nil, fmt.Errorf("bencode: unexpected byte %q", b)
+	}
+}
+
+func decodeBencodeInt(r *bufio.Reader) (int64, error) {
+	s, err := r.ReadString('e')
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s[:len(s)-1], 10, 64)
+}
+
+func decodeBencodeString(r *bufio.Reader) (string, error) {
+	lenStr, err := r.ReadString(':')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(lenStr[:len(lenStr)-1])
+	if err != nil {
+		return "", fmt.Errorf("bencode: invalid string length %q: %w", lenStr, err)
+	}
+	if n < 0 {
+		return "", fmt.Errorf("bencode: negative string length %q", lenStr)
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
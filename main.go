@@ -10,7 +10,9 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/integrii/flaggy"
@@ -50,6 +52,13 @@ type TorboxDownloadResponse struct {
 	Data   string
 }
 
+// downloadJob pairs a torrent with one of its files, the unit of work handed
+// to the download worker pool.
+type downloadJob struct {
+	torrent     TorboxTorrent
+	torrentfile TorboxTorrentFile
+}
+
 var TORBOX_API_KEY = os.Getenv("TORBOX_API_KEY")
 
 func main() {
@@ -59,6 +68,11 @@ func main() {
 	var torboxBody []byte
 	var client http.Client
 	var torrentNameHint string
+	var parallel int = 4
+	var torrentFilePath string
+	var webseedFlag string
+	var storageFlag string
+	var blobRoot string
 
 	subcommandList := flaggy.NewSubcommand("list")
 	subcommandList.Bool(&isHumanReadable, "H", "human-readable", "Human-readable output")
@@ -67,12 +81,31 @@ func main() {
 
 	subcommandDownload := flaggy.NewSubcommand("download")
 	subcommandDownload.AddPositionalValue(&torrentNameHint, "NAME", 1, false, "The name of the torrent to download")
+	subcommandDownload.Int(&parallel, "p", "parallel", "Number of files to download concurrently")
+	subcommandDownload.String(&webseedFlag, "", "webseed", "Comma-separated web seed root URLs to fall back to when torbox.app fails")
+	subcommandDownload.String(&storageFlag, "", "storage", "Storage backend to write downloads with: file, mmap, or blob (default file)")
+	subcommandDownload.String(&blobRoot, "", "blob-root", "Root directory for the blob storage backend (default ~/.cache/torbox/blobs)")
 	flaggy.AttachSubcommand(subcommandDownload, 1)
 
+	subcommandVerify := flaggy.NewSubcommand("verify")
+	subcommandVerify.AddPositionalValue(&torrentFilePath, "PATH", 1, true, "Path to a .torrent file")
+	flaggy.AttachSubcommand(subcommandVerify, 1)
+
+	subcommandPick := flaggy.NewSubcommand("pick")
+	subcommandPick.AddPositionalValue(&torrentNameHint, "NAME", 1, false, "Only list files matching this torrent name or glob")
+	flaggy.AttachSubcommand(subcommandPick, 1)
+
 	flaggy.SetName("torbox")
 	flaggy.DefaultParser.DisableShowVersionWithVersion()
 	flaggy.Parse()
 
+	if subcommandVerify.Used {
+		if err := verifyTorrent(torrentFilePath, "."); err != nil {
+			Error("%s", err)
+		}
+		return
+	}
+
 	if TORBOX_API_KEY == "" {
 		Warn("TORBOX_API_KEY environment variable is not set; torbox will likely fail to authenticate with torbox.app.")
 	}
@@ -104,6 +137,13 @@ func main() {
 		Error("failed to decode JSON response: %s", err)
 	}
 
+	if subcommandPick.Used {
+		if err := runPick(ttl, torrentNameHint); err != nil {
+			Error("%s", err)
+		}
+		return
+	}
+
 	if subcommandList.Used {
 		if isJSON {
 			fmt.Println(string(torboxBody))
@@ -122,125 +162,270 @@ func main() {
 		return
 	}
 
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var webseeds []string
+	if webseedFlag == "" {
+		webseedFlag = os.Getenv("TORBOX_WEBSEEDS")
+	}
+	for _, root := range strings.Split(webseedFlag, ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			webseeds = append(webseeds, root)
+		}
+	}
+
+	if blobRoot == "" {
+		blobRoot = filepath.Join(os.Getenv("HOME"), ".cache", "torbox", "blobs")
+	}
+	storage, err := NewStorage(storageFlag, blobRoot)
+	if err != nil {
+		Error("%s", err)
+	}
+
+	picks, err := loadPicks()
+	if err != nil {
+		Error("failed to load picks: %s", err)
+	}
+
+	var jobs []downloadJob
 	for _, torrent := range ttl.Data {
 		for _, torrentfile := range torrent.Files {
 			if torrentNameHint == "" || torrentNameHint == torrent.Name || glob.Glob(torrentNameHint, torrentfile.Name) {
-				var downloadRequest TorboxDownloadResponse
-
-				if stat, err := os.Stat(torrentfile.Name); err == nil && stat.Size() == torrentfile.Size {
-					Info("%s: file already exists", torrentfile.Name)
-					if torrentfile.MD5 == "" {
-						// The file already exists and it has the expected size. Unfortunately, we cannot
-						// verify the MD5 hash because it wasn't provided to us by TorBox, so let's assume
-						// it's the same file we would download, and skip to the next one.
-						continue
-					} else {
-						f, err := os.Open(torrentfile.Name)
-						if err == nil {
-							hash := md5.New()
-							if _, err = io.Copy(hash, f); err == nil {
-								if fmt.Sprintf("%x", hash.Sum(nil)) == torrentfile.MD5 {
-									Info("%s: MD5 OK", torrentfile.Name)
-									continue
-								} else {
-									Warn("%s: MD5 FAILED (expected %s; got %s)", torrentfile.Name, torrentfile.MD5, fmt.Sprintf("%x", hash.Sum(nil)))
-								}
-							}
+				if picks[torrent.Hash][torrentfile.ID] == PrioritySkip {
+					Info("%s: skipped (marked skip by pick)", torrentfile.Name)
+					continue
+				}
+				jobs = append(jobs, downloadJob{torrent: torrent, torrentfile: torrentfile})
+			}
+		}
+	}
+
+	// Files picked "high" are fed to the worker pool first; a stable sort
+	// keeps everything else in its original order.
+	sort.SliceStable(jobs, func(i, j int) bool {
+		hi := picks[jobs[i].torrent.Hash][jobs[i].torrentfile.ID] == PriorityHigh
+		hj := picks[jobs[j].torrent.Hash][jobs[j].torrentfile.ID] == PriorityHigh
+		return hi && !hj
+	})
+
+	jobCh := make(chan downloadJob)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobCh {
+				downloadFile(&client, job.torrent, job.torrentfile, webseeds, storage)
+			}
+		}()
+	}
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+	wg.Wait()
+}
+
+// downloadFile fetches a single torrent file, resuming from the current
+// on-disk size (if any) via an HTTP Range request and seeding the running
+// MD5 from the bytes already on disk so a resumed download still produces a
+// hash over the whole file. It is safe to call concurrently from multiple
+// workers.
+func downloadFile(client *http.Client, torrent TorboxTorrent, torrentfile TorboxTorrentFile, webseeds []string, storage Storage) {
+	var resumeOffset int64
+
+	if stat, err := os.Stat(torrentfile.Name); err == nil {
+		if stat.Size() == torrentfile.Size {
+			Info("%s: file already exists", torrentfile.Name)
+			if torrentfile.MD5 == "" {
+				// The file already exists and it has the expected size. Unfortunately, we cannot
+				// verify the MD5 hash because it wasn't provided to us by TorBox, so let's assume
+				// it's the same file we would download, and skip to the next one.
+				return
+			} else {
+				f, err := os.Open(torrentfile.Name)
+				if err == nil {
+					hash := md5.New()
+					if _, err = io.Copy(hash, f); err == nil {
+						if fmt.Sprintf("%x", hash.Sum(nil)) == torrentfile.MD5 {
+							Info("%s: MD5 OK", torrentfile.Name)
+							return
+						} else {
+							Warn("%s: MD5 FAILED (expected %s; got %s)", torrentfile.Name, torrentfile.MD5, fmt.Sprintf("%x", hash.Sum(nil)))
 						}
 					}
 				}
+			}
+		} else if stat.Size() < torrentfile.Size {
+			resumeOffset = stat.Size()
+		}
+	}
 
-				req, err := http.NewRequest("GET", fmt.Sprintf("https://api.torbox.app/v1/api/torrents/requestdl?token=%s&torrent_id=%d&file_id=%d&zip=false", TORBOX_API_KEY, torrent.ID, torrentfile.ID), nil)
-				if err != nil {
-					Error("failed to create HTTP request object: %s", err)
-				}
-				resp, err := client.Do(req)
-				if err != nil {
-					Error("HTTP request failed: %s", err)
-				}
-				if resp.StatusCode != http.StatusOK {
-					Error("expected HTTP status 200, got: %s", resp.Status)
-				}
-				json.NewDecoder(resp.Body).Decode(&downloadRequest)
-				if err = resp.Body.Close(); err != nil {
-					Warn("failed to close HTTP response body: %s", err)
-				}
-				req, err = http.NewRequest("GET", downloadRequest.Data, nil)
-				if err != nil {
-					Error("failed to create HTTP request object: %s", err)
-				}
-				resp, err = client.Do(req)
-				if err != nil {
-					Error("HTTP request failed: %s", err)
-				}
-				if resp.StatusCode != http.StatusOK {
-					Error("expected HTTP status 200, got: %s", resp.Status)
-				}
-				if err = resp.Body.Close(); err != nil {
-					Warn("failed to close HTTP response body: %s", err)
+	if torrentfile.MD5 != "" {
+		if bs, ok := storage.(*blobStorage); ok {
+			if blobPath, found := bs.Lookup(torrentfile.MD5); found {
+				if err := linkBlob(blobPath, torrentfile.Name); err != nil {
+					Error("failed to link existing blob for '%s': %s", torrentfile.Name, err)
 				}
+				Info("%s: already have this blob, skipped the download", torrentfile.Name)
+				return
+			}
+		}
+	}
 
-				if err = os.MkdirAll(filepath.Dir(torrentfile.Name), 0755); err != nil {
-					Error("failed to create directory '%s': %s", filepath.Dir(torrentfile.Name), err)
-				}
+	var downloadRequest TorboxDownloadResponse
+	req, err := http.NewRequest("GET", fmt.Sprintf("https://api.torbox.app/v1/api/torrents/requestdl?token=%s&torrent_id=%d&file_id=%d&zip=false", TORBOX_API_KEY, torrent.ID, torrentfile.ID), nil)
+	if err != nil {
+		Error("failed to create HTTP request object: %s", err)
+	}
+	resp, err := client.Do(req)
+	if err == nil && resp.StatusCode == http.StatusOK {
+		json.NewDecoder(resp.Body).Decode(&downloadRequest)
+		if err = resp.Body.Close(); err != nil {
+			Warn("failed to close HTTP response body: %s", err)
+		}
+	} else if len(webseeds) == 0 {
+		if err != nil {
+			Error("HTTP request failed: %s", err)
+		}
+		Error("expected HTTP status 200, got: %s", resp.Status)
+	} else {
+		if err != nil {
+			Warn("requestdl failed: %s", err)
+		} else {
+			Warn("requestdl failed: expected HTTP status 200, got: %s", resp.Status)
+		}
+	}
 
-				// Download the file.
-				out, err := os.OpenFile(torrentfile.Name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-				if err != nil {
-					Error("failed to create file '%s': %s", torrentfile.Name, err)
-				}
-				defer out.Close()
+	if err = os.MkdirAll(filepath.Dir(torrentfile.Name), 0755); err != nil {
+		Error("failed to create directory '%s': %s", filepath.Dir(torrentfile.Name), err)
+	}
 
-				req, err = http.NewRequest("GET", downloadRequest.Data, nil)
-				if err != nil {
-					Error("failed to create HTTP request object: %s", err)
-				}
+	hash := md5.New()
+	if resumeOffset > 0 {
+		f, err := os.Open(torrentfile.Name)
+		if err != nil {
+			Error("failed to open file '%s' to seed resume hash: %s", torrentfile.Name, err)
+		}
+		if _, err = io.Copy(hash, f); err != nil {
+			Error("failed to hash on-disk prefix of '%s': %s", torrentfile.Name, err)
+		}
+		f.Close()
+		Info("%s: resuming at byte %d", torrentfile.Name, resumeOffset)
+	}
 
-				// The torbox.app service is not five 9s reliable. Sometimes, it can
-				// take a while for a connection to "succeed." Retry up to 10 times.
-				for i := 0; i < 10; i++ {
-					Info("Attempting to download '%s'... (#%d)", torrentfile.Name, i+1)
-					resp, err = client.Do(req)
-					if err != nil {
-						Error("HTTP request failed: %s", err)
-					}
-					if resp.StatusCode == http.StatusOK {
-						break
-					}
-					Warn("expected HTTP status 200, got: %s", resp.Status)
-					time.Sleep((1 << i) * time.Second)
-				}
+	if resumeOffset == 0 {
+		// Whatever is already at this name (an oversized file, or one that
+		// failed its MD5 check) is not a prefix of this download; start over.
+		if err = os.Remove(torrentfile.Name); err != nil && !os.IsNotExist(err) {
+			Error("failed to remove stale file '%s': %s", torrentfile.Name, err)
+		}
+	}
 
-				Info("Downloading '%s'...", torrentfile.Name)
-				hash := md5.New()
-				buffer := make([]byte, 64<<10) // 64 KiB
-				for {
-					n, err := resp.Body.Read(buffer)
-					if err != nil && err != io.EOF {
-						Error("failed to read from HTTP response body: %s", err)
-					}
-					if n == 0 {
-						break
-					}
-					if _, err = out.Write(buffer[:n]); err != nil {
-						Error("failed to write to file '%s': %s", torrentfile.Name, err)
-					}
-					if _, err = hash.Write(buffer[:n]); err != nil {
-						Error("failed to generate an MD5 hash of the download: %s", err)
-					}
-				}
+	// Download the file.
+	out, err := storage.Create(torrentfile.Name, torrentfile.Size)
+	if err != nil {
+		Error("failed to create file '%s': %s", torrentfile.Name, err)
+	}
+	defer out.Close()
 
-				Info("Downloaded '%s' (%s)", torrentfile.Name, HumanReadableSize(torrentfile.Size))
+	remaining := torrentfile.Size - resumeOffset
+	var body io.ReadCloser
+	if downloadRequest.Data != "" {
+		body, err = fetchWithRetry(client, downloadRequest.Data, resumeOffset, remaining, torrentfile.Name)
+	}
+	if body == nil {
+		for _, root := range webseeds {
+			url := strings.TrimRight(root, "/") + "/" + torrent.Name + "/" + torrentfile.Name
+			Info("falling back to web seed '%s'", url)
+			if body, err = fetchWithRetry(client, url, resumeOffset, remaining, torrentfile.Name); body != nil {
+				break
+			}
+		}
+	}
+	if body == nil {
+		Error("failed to download '%s' from torbox.app or any web seed: %s", torrentfile.Name, err)
+	}
+	defer body.Close()
 
-				if fmt.Sprintf("%x", hash.Sum(nil)) == torrentfile.MD5 {
-					Info("%s: MD5 OK", torrentfile.Name)
-				} else {
-					Warn("%s: MD5 FAILED (expected %s; got %s)", torrentfile.Name, torrentfile.MD5, fmt.Sprintf("%x", hash.Sum(nil)))
-					continue
-				}
+	Info("Downloading '%s'...", torrentfile.Name)
+	offset := resumeOffset
+	buffer := make([]byte, 64<<10) // 64 KiB
+	for {
+		n, err := body.Read(buffer)
+		if err != nil && err != io.EOF {
+			Error("failed to read from HTTP response body: %s", err)
+		}
+		if n == 0 {
+			break
+		}
+		if _, err = out.WriteAt(buffer[:n], offset); err != nil {
+			Error("failed to write to file '%s': %s", torrentfile.Name, err)
+		}
+		offset += int64(n)
+		if _, err = hash.Write(buffer[:n]); err != nil {
+			Error("failed to generate an MD5 hash of the download: %s", err)
+		}
+	}
+
+	Info("Downloaded '%s' (%s)", torrentfile.Name, HumanReadableSize(torrentfile.Size))
+
+	if fmt.Sprintf("%x", hash.Sum(nil)) == torrentfile.MD5 {
+		Info("%s: MD5 OK", torrentfile.Name)
+	} else {
+		Warn("%s: MD5 FAILED (expected %s; got %s)", torrentfile.Name, torrentfile.MD5, fmt.Sprintf("%x", hash.Sum(nil)))
+	}
+}
+
+// fetchWithRetry issues a GET to url, resuming at resumeOffset via a Range
+// header when non-zero, retrying up to 10 times with exponential backoff. It
+// serves both the direct torbox.app URL and each web seed. It returns the response body
+// on success or a nil body and the last error/status otherwise, so callers
+// can fall back to another source instead of treating a failure as fatal.
+//
+// When resumeOffset is non-zero, only http.StatusPartialContent is accepted
+// as success: a server that ignores the Range header and answers 200 would
+// otherwise hand back the whole file, which downloadFile then writes
+// starting at resumeOffset, corrupting the file with a shifted copy of its
+// own content. As a second line of defense - web seeds are far less likely
+// than torbox.app's CDN to honor Range correctly - a response whose
+// Content-Length disagrees with wantSize (the number of bytes downloadFile
+// still expects) is rejected too, rather than trusted at face value.
+func fetchWithRetry(client *http.Client, url string, resumeOffset, wantSize int64, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request object: %w", err)
+	}
+	if resumeOffset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeOffset))
+	}
+
+	var resp *http.Response
+	for i := 0; i < 10; i++ {
+		Info("Attempting to download '%s'... (#%d)", name, i+1)
+		resp, err = client.Do(req)
+		if err != nil {
+			Warn("HTTP request failed: %s", err)
+		} else if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && resumeOffset == 0) {
+			if resp.StatusCode == http.StatusOK {
+				err = fmt.Errorf("resume at byte %d requested but server ignored Range and returned a full 200 response", resumeOffset)
+			} else {
+				err = fmt.Errorf("expected HTTP status 200, got: %s", resp.Status)
 			}
+			resp.Body.Close()
+			Warn("%s", err)
+		} else if resp.ContentLength >= 0 && resp.ContentLength != wantSize {
+			err = fmt.Errorf("expected %d bytes, server reported Content-Length %d", wantSize, resp.ContentLength)
+			resp.Body.Close()
+			Warn("%s", err)
+		} else {
+			return resp.Body, nil
 		}
+		time.Sleep((1 << i) * time.Second)
 	}
+	return nil, err
 }
 
 // HumanReadableSize takes a file size as an integer value and returns a string
@@ -255,15 +440,23 @@ func HumanReadableSize(size int64) string {
 	return "?iB"
 }
 
+var logMu sync.Mutex
+
 func Info(msg string, args ...any) {
+	logMu.Lock()
+	defer logMu.Unlock()
 	log.Printf("INFO "+msg, args...)
 }
 
 func Warn(msg string, args ...any) {
+	logMu.Lock()
+	defer logMu.Unlock()
 	log.Printf("WARN "+msg, args...)
 }
 
 func Error(msg string, args ...any) {
+	logMu.Lock()
+	defer logMu.Unlock()
 	log.Fatalf("ERROR "+msg, args...)
 }
 
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ryanuber/go-glob"
+)
+
+// PickPriority marks how a selected file should be treated by a future
+// download: high and skip let the user steer a download ahead of time,
+// normal is the default for anything merely selected.
+type PickPriority string
+
+const (
+	PriorityHigh   PickPriority = "high"
+	PriorityNormal PickPriority = "normal"
+	PrioritySkip   PickPriority = "skip"
+)
+
+// PickStore is the on-disk shape of ~/.config/torbox/picks.json: torrent
+// hash to file ID to priority. Keying by hash (rather than name) means a
+// selection survives a torrent being renamed.
+type PickStore map[string]map[int]PickPriority
+
+func picksPath() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "torbox", "picks.json")
+}
+
+func loadPicks() (PickStore, error) {
+	data, err := os.ReadFile(picksPath())
+	if os.IsNotExist(err) {
+		return make(PickStore), nil
+	} else if err != nil {
+		return nil, err
+	}
+	store := make(PickStore)
+	if err = json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", picksPath(), err)
+	}
+	return store, nil
+}
+
+func savePicks(store PickStore) error {
+	path := picksPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// pickEntry is one numbered row of the picker: a single file belonging to a
+// single torrent.
+type pickEntry struct {
+	torrent TorboxTorrent
+	file    TorboxTorrentFile
+}
+
+// fileProgress estimates how much of file is already on disk, the same way
+// downloadFile decides whether to resume: by comparing the on-disk size
+// against the expected size. TorboxTorrentFile (unlike TorboxTorrent) has no
+// progress field of its own to report.
+func fileProgress(file TorboxTorrentFile) int {
+	if file.Size <= 0 {
+		return 100
+	}
+	stat, err := os.Stat(file.Name)
+	if err != nil {
+		return 0
+	}
+	if stat.Size() >= file.Size {
+		return 100
+	}
+	return int(stat.Size() * 100 / file.Size)
+}
+
+// runPick lists every file across ttl.Data matching nameHint (or all files,
+// if nameHint is empty), lets the user select a subset and/or assign
+// priorities to it via stdin, and persists the result to picks.json keyed
+// by torrent hash so a later `pick` invocation resumes the same selection.
+// A non-empty selection is authoritative for every file listed this run:
+// anything not mentioned is recorded as "skip", so `download` only fetches
+// the subset the user actually picked.
+func runPick(ttl TorboxTorrentList, nameHint string) error {
+	var entries []pickEntry
+	for _, torrent := range ttl.Data {
+		for _, file := range torrent.Files {
+			if nameHint == "" || nameHint == torrent.Name || glob.Glob(nameHint, file.Name) {
+				entries = append(entries, pickEntry{torrent: torrent, file: file})
+			}
+		}
+	}
+	if len(entries) == 0 {
+		Info("no files matched '%s'", nameHint)
+		return nil
+	}
+
+	store, err := loadPicks()
+	if err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		priority := store[e.torrent.Hash][e.file.ID]
+		if priority == "" {
+			priority = PriorityNormal
+		}
+		fmt.Printf("%3d  %-6s %3d%%  %s  %s\n", i+1, priority, fileProgress(e.file), HumanReadableSize(e.file.Size), e.file.Name)
+	}
+
+	fmt.Print("Select files (e.g. 1,3,5-8 or 1:high,5-8:skip), or press Enter to keep the current selection: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("failed to read selection: %w", err)
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return nil
+	}
+
+	selection, err := parsePickSelection(line, len(entries))
+	if err != nil {
+		return err
+	}
+
+	// The typed selection is authoritative for everything listed: a file
+	// left out of it is exactly as "not wanted" as one explicitly marked
+	// skip, so record it that way rather than leaving it to download
+	// unnoticed.
+	for i, e := range entries {
+		priority, ok := selection[i+1]
+		if !ok {
+			priority = PrioritySkip
+		}
+		if store[e.torrent.Hash] == nil {
+			store[e.torrent.Hash] = make(map[int]PickPriority)
+		}
+		store[e.torrent.Hash][e.file.ID] = priority
+	}
+
+	return savePicks(store)
+}
+
+// parsePickSelection parses a comma-separated list of indices and ranges,
+// each optionally suffixed with ":priority" (default "normal"), e.g.
+// "1,3,5-8:skip". Indices are 1-based and must fall within [1, max].
+func parsePickSelection(input string, max int) (map[int]PickPriority, error) {
+	selection := make(map[int]PickPriority)
+	for _, token := range strings.Split(input, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		spec, priority := token, PriorityNormal
+		if idx := strings.Index(token, ":"); idx >= 0 {
+			spec = token[:idx]
+			switch p := PickPriority(token[idx+1:]); p {
+			case PriorityHigh, PriorityNormal, PrioritySkip:
+				priority = p
+			default:
+				return nil, fmt.Errorf("unknown priority '%s'", token[idx+1:])
+			}
+		}
+
+		lo, hi := 0, 0
+		if dash := strings.Index(spec, "-"); dash >= 0 {
+			var err error
+			if lo, err = strconv.Atoi(spec[:dash]); err != nil {
+				return nil, fmt.Errorf("invalid range '%s'", spec)
+			}
+			if hi, err = strconv.Atoi(spec[dash+1:]); err != nil {
+				return nil, fmt.Errorf("invalid range '%s'", spec)
+			}
+		} else {
+			n, err := strconv.Atoi(spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid index '%s'", spec)
+			}
+			lo, hi = n, n
+		}
+
+		for i := lo; i <= hi; i++ {
+			if i < 1 || i > max {
+				return nil, fmt.Errorf("index %d out of range (1-%d)", i, max)
+			}
+			selection[i] = priority
+		}
+	}
+	return selection, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/edsrzf/mmap-go"
+)
+
+// Storage decides where and how a downloaded file's bytes land on disk. It
+// decouples the download loop in downloadFile from on-disk layout, so that
+// resume, mmap'd parallel writes, and content-addressed dedup can each live
+// behind the same WriteAt-shaped interface.
+type Storage interface {
+	// Create prepares name for writing size bytes and returns a handle to
+	// write into it. size is the final file size as reported by TorBox, used
+	// by storage backends that need to pre-allocate.
+	Create(name string, size int64) (StorageWriter, error)
+}
+
+// StorageWriter is the handle returned by Storage.Create. WriteAt must be
+// safe to call with a monotonically increasing offset, which is the only
+// access pattern the download loop uses.
+type StorageWriter interface {
+	WriteAt(p []byte, off int64) (int, error)
+	Close() error
+}
+
+// NewStorage returns the Storage backend named by kind ("file", "mmap", or
+// "blob"), matching the --storage flag.
+func NewStorage(kind, blobRoot string) (Storage, error) {
+	switch kind {
+	case "", "file":
+		return fileStorage{}, nil
+	case "mmap":
+		return mmapStorage{}, nil
+	case "blob":
+		return &blobStorage{root: blobRoot}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend '%s' (want file, mmap, or blob)", kind)
+	}
+}
+
+// fileStorage is the original append-to-file behavior: it opens name for
+// append and ignores the offset on every write, relying on the caller to
+// have already written (or resumed from) the right prefix.
+type fileStorage struct{}
+
+func (fileStorage) Create(name string, size int64) (StorageWriter, error) {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &appendWriter{f: f}, nil
+}
+
+type appendWriter struct {
+	f *os.File
+}
+
+func (w *appendWriter) WriteAt(p []byte, _ int64) (int, error) {
+	return w.f.Write(p)
+}
+
+func (w *appendWriter) Close() error {
+	return w.f.Close()
+}
+
+// mmapStorage pre-allocates the full file and writes at absolute offsets
+// through a read-write mmap, which is what lets parallel range downloads
+// land out of order within a single file.
+type mmapStorage struct{}
+
+func (mmapStorage) Create(name string, size int64) (StorageWriter, error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	m, err := mmap.Map(f, mmap.RDWR, 0)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &mmapWriter{f: f, m: m}, nil
+}
+
+type mmapWriter struct {
+	f *os.File
+	m mmap.MMap
+}
+
+func (w *mmapWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(p)) > int64(len(w.m)) {
+		return 0, fmt.Errorf("write of %d bytes at offset %d exceeds mmap size %d", len(p), off, len(w.m))
+	}
+	return copy(w.m[off:], p), nil
+}
+
+func (w *mmapWriter) Close() error {
+	if err := w.m.Flush(); err != nil {
+		return err
+	}
+	if err := w.m.Unmap(); err != nil {
+		return err
+	}
+	return w.f.Close()
+}
+
+// blobStorage writes each file into <root>/<md5-prefix>/<md5> and symlinks
+// the human-readable name to it, so a file already present under a
+// different name ends up as a symlink rather than a second copy on disk.
+// That dedup is only discovered once the transfer finishes and the digest
+// can be confirmed - downloadFile still fetches the full payload over the
+// network. Lookup lets a caller that already knows the expected MD5 (see
+// downloadFile) skip the network transfer entirely instead of just the
+// disk space.
+type blobStorage struct {
+	root string
+}
+
+// Lookup returns the path of an existing blob for digest, if one is already
+// on disk.
+func (b *blobStorage) Lookup(digest string) (string, bool) {
+	path := filepath.Join(b.root, digest[:2], digest)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+func (b *blobStorage) Create(name string, size int64) (StorageWriter, error) {
+	if err := os.MkdirAll(b.root, 0755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(b.root, "blob-*")
+	if err != nil {
+		return nil, err
+	}
+	w := &blobWriter{root: b.root, name: name, tmp: tmp, hash: md5.New()}
+
+	// The caller (downloadFile) may be resuming: it writes only the newly
+	// fetched tail, at an offset that starts past whatever's already on
+	// disk at name. Seed the temp file with that on-disk prefix, and fold
+	// it into the hash, so the blob we end up with - and its digest - cover
+	// the whole file, not just the tail.
+	if existing, err := os.Open(name); err == nil {
+		_, copyErr := io.Copy(io.MultiWriter(tmp, w.hash), existing)
+		existing.Close()
+		if copyErr != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return nil, fmt.Errorf("failed to seed blob from on-disk prefix of '%s': %w", name, copyErr)
+		}
+	}
+
+	return w, nil
+}
+
+// blobWriter hashes the bytes it's given as it writes them, so it requires
+// writes in increasing offset order, which is the only pattern downloadFile
+// ever produces for a single file.
+type blobWriter struct {
+	root string
+	name string
+	tmp  *os.File
+	hash hash.Hash
+	mu   sync.Mutex
+}
+
+func (w *blobWriter) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.tmp.WriteAt(p, off)
+	if n > 0 {
+		w.hash.Write(p[:n])
+	}
+	return n, err
+}
+
+func (w *blobWriter) Close() error {
+	if err := w.tmp.Close(); err != nil {
+		return err
+	}
+
+	digest := fmt.Sprintf("%x", w.hash.Sum(nil))
+	dir := filepath.Join(w.root, digest[:2])
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	blobPath := filepath.Join(dir, digest)
+	if _, err := os.Stat(blobPath); err == nil {
+		// Someone already has this exact blob; drop the duplicate temp file.
+		if err = os.Remove(w.tmp.Name()); err != nil {
+			return err
+		}
+	} else if err = os.Rename(w.tmp.Name(), blobPath); err != nil {
+		return err
+	}
+
+	return linkBlob(blobPath, w.name)
+}
+
+// linkBlob replaces name with a symlink to blobPath, creating name's parent
+// directory if needed. It's shared by blobWriter.Close, which links a blob
+// it just finished writing, and downloadFile's blobStorage.Lookup
+// short-circuit, which links a blob it already had without fetching it.
+func linkBlob(blobPath, name string) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	absBlobPath, err := filepath.Abs(blobPath)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(absBlobPath, name)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/exp/mmap"
+)
+
+// MetainfoFile is a single file entry from a (possibly multi-file) torrent's
+// info dictionary.
+type MetainfoFile struct {
+	Path   string // path relative to the verified directory; for multi-file torrents it starts with the torrent's name
+	Length int64
+}
+
+// Metainfo is the subset of a .torrent file's bencoded metainfo that verify
+// needs: the piece layout and the file list.
+type Metainfo struct {
+	Name        string
+	PieceLength int64
+	Pieces      []string // one SHA-1 digest (20 bytes) per piece
+	Files       []MetainfoFile
+}
+
+// maxPieceLength bounds a torrent's piece length to something no real
+// client would ever produce (real-world torrents top out well under 64
+// MiB), so a malformed or hostile metainfo can't force an absurd
+// make([]byte, PieceLength) allocation in verifyTorrent.
+const maxPieceLength = 1 << 28 // 256 MiB
+
+// parseMetainfo reads and decodes a .torrent file at path into a Metainfo.
+func parseMetainfo(path string) (*Metainfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open torrent file '%s': %w", path, err)
+	}
+	defer f.Close()
+
+	decoded, err := decodeBencode(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode torrent file '%s': %w", path, err)
+	}
+	top, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("torrent file '%s': top-level value is not a dictionary", path)
+	}
+	info, ok := top["info"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("torrent file '%s': missing 'info' dictionary", path)
+	}
+
+	name, _ := info["name"].(string)
+	pieceLength, _ := info["piece length"].(int64)
+	pieces, _ := info["pieces"].(string)
+	if pieceLength <= 0 || pieceLength > maxPieceLength {
+		return nil, fmt.Errorf("torrent file '%s': 'piece length' %d out of range (want 1-%d)", path, pieceLength, maxPieceLength)
+	}
+	if len(pieces) == 0 || len(pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("torrent file '%s': malformed 'pieces'", path)
+	}
+
+	mi := &Metainfo{Name: name, PieceLength: pieceLength}
+	for i := 0; i < len(pieces); i += sha1.Size {
+		mi.Pieces = append(mi.Pieces, pieces[i:i+sha1.Size])
+	}
+
+	if files, ok := info["files"].([]any); ok {
+		// Multi-file torrent: each file lives under a directory named Name.
+		for _, fi := range files {
+			fdict, ok := fi.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("torrent file '%s': malformed entry in 'files' list", path)
+			}
+			length, _ := fdict["length"].(int64)
+			pathList, _ := fdict["path"].([]any)
+			parts := make([]string, 0, len(pathList)+1)
+			parts = append(parts, name)
+			for _, p := range pathList {
+				s, _ := p.(string)
+				parts = append(parts, s)
+			}
+			mi.Files = append(mi.Files, MetainfoFile{Path: filepath.Join(parts...), Length: length})
+		}
+	} else {
+		// Single-file torrent: Name is the file itself.
+		length, _ := info["length"].(int64)
+		mi.Files = append(mi.Files, MetainfoFile{Path: name, Length: length})
+	}
+
+	return mi, nil
+}
+
+// span is a read-only, mmap-backed view over a torrent's file list
+// concatenated in order, the same logical layout BitTorrent piece hashes are
+// computed against. Files that are missing on disk read back as zero bytes
+// so that partial datasets still report which pieces are intact.
+type span struct {
+	files  []MetainfoFile
+	starts []int64 // starts[i] is the offset of files[i] within the span
+	total  int64
+	mmaps  []*mmap.ReaderAt // nil entries mean the file is missing or unreadable
+}
+
+// openSpan mmaps every file in files (read-only) relative to baseDir.
+// Missing files are recorded but not treated as fatal.
+func openSpan(files []MetainfoFile, baseDir string) *span {
+	s := &span{files: files}
+	for _, file := range files {
+		s.starts = append(s.starts, s.total)
+		s.total += file.Length
+
+		r, err := mmap.Open(filepath.Join(baseDir, file.Path))
+		if err != nil {
+			Warn("%s: %s", file.Path, err)
+			s.mmaps = append(s.mmaps, nil)
+			continue
+		}
+		s.mmaps = append(s.mmaps, r)
+	}
+	return s
+}
+
+func (s *span) Close() {
+	for _, r := range s.mmaps {
+		if r != nil {
+			r.Close()
+		}
+	}
+}
+
+// readAt fills buf with the span's bytes starting at off, zero-filling any
+// region that falls in a missing file or past the end of a short one.
+func (s *span) readAt(buf []byte, off int64) {
+	for i, file := range s.files {
+		fileStart, fileEnd := s.starts[i], s.starts[i]+file.Length
+		bufStart, bufEnd := off, off+int64(len(buf))
+		if fileEnd <= bufStart || fileStart >= bufEnd {
+			continue
+		}
+
+		lo, hi := max64(fileStart, bufStart), min64(fileEnd, bufEnd)
+		dst := buf[lo-bufStart : hi-bufStart]
+		if s.mmaps[i] == nil {
+			clear(dst)
+			continue
+		}
+		n, err := s.mmaps[i].ReadAt(dst, lo-fileStart)
+		if err != nil && n < len(dst) {
+			clear(dst[n:])
+		}
+	}
+}
+
+// overlapping returns the indices into files of every file that the byte
+// range [off, off+n) touches.
+func (s *span) overlapping(off, n int64) []int {
+	var idx []int
+	for i, file := range s.files {
+		if s.starts[i] < off+n && s.starts[i]+file.Length > off {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// verifyTorrent checks the files in baseDir referenced by the torrent at
+// torrentPath against its piece hashes, printing per-piece and per-file
+// pass/fail counts.
+func verifyTorrent(torrentPath, baseDir string) error {
+	mi, err := parseMetainfo(torrentPath)
+	if err != nil {
+		return err
+	}
+
+	s := openSpan(mi.Files, baseDir)
+	defer s.Close()
+
+	filePass := make([]int, len(mi.Files))
+	fileFail := make([]int, len(mi.Files))
+	var piecesOK int
+
+	buf := make([]byte, mi.PieceLength)
+	for i, want := range mi.Pieces {
+		off := int64(i) * mi.PieceLength
+		n := min64(mi.PieceLength, s.total-off)
+		if n <= 0 {
+			break
+		}
+		chunk := buf[:n]
+		s.readAt(chunk, off)
+
+		sum := sha1.Sum(chunk)
+		ok := string(sum[:]) == want
+		if ok {
+			piecesOK++
+		}
+		for _, fi := range s.overlapping(off, n) {
+			if ok {
+				filePass[fi]++
+			} else {
+				fileFail[fi]++
+			}
+		}
+	}
+
+	for i, file := range mi.Files {
+		fmt.Printf("%s: %d/%d pieces OK\n", file.Path, filePass[i], filePass[i]+fileFail[i])
+	}
+	fmt.Printf("%d/%d pieces OK\n", piecesOK, len(mi.Pieces))
+
+	return nil
+}